@@ -23,11 +23,15 @@ package db
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -131,6 +135,54 @@ func (q *QueryStatus) String() string {
 	return "\t" + strings.Replace(strings.Join(lines, "\n"), "\n", "\n\t", -1) + "\n\n"
 }
 
+// Fields returns q as a flat map of key/value pairs, suitable for structured
+// logging with libraries such as logrus, zap or klog. Fields that have no
+// meaningful value (no rows affected, no error, etc.) are omitted rather
+// than included as zero values.
+func (q *QueryStatus) Fields() map[string]interface{} {
+	fields := make(map[string]interface{}, 8)
+
+	if q.SessID > 0 {
+		fields["session_id"] = q.SessID
+	}
+
+	if q.TxID > 0 {
+		fields["tx_id"] = q.TxID
+	}
+
+	if query := q.Query; query != "" {
+		query = reInvisibleChars.ReplaceAllString(query, ` `)
+		fields["query"] = strings.TrimSpace(query)
+	}
+
+	if len(q.Args) > 0 {
+		fields["args"] = q.Args
+	}
+
+	if q.RowsAffected != nil {
+		fields["rows_affected"] = *q.RowsAffected
+	}
+	if q.LastInsertID != nil {
+		fields["last_insert_id"] = *q.LastInsertID
+	}
+
+	if q.Err != nil {
+		fields["error"] = q.Err.Error()
+	}
+
+	fields["duration_ms"] = float64(q.End.UnixNano()-q.Start.UnixNano()) / float64(1e6)
+
+	if q.Context != nil {
+		if entry, ok := FromContext(q.Context); ok {
+			for k, v := range entry.fields {
+				fields[k] = v
+			}
+		}
+	}
+
+	return fields
+}
+
 const (
 	defaultLogLevel = LogLevelWarn
 )
@@ -143,16 +195,355 @@ type Logger interface {
 	Panicf(format string, v ...interface{})
 }
 
+// StructuredLogger is implemented by loggers that consume field-based log
+// entries instead of a free-form formatted message, in the style of logrus
+// or klog. LoggingCollector builds the field map from a QueryStatus (see
+// QueryStatus.Fields) and hands it off as-is, so the receiving end decides
+// how to render or ship it.
+type StructuredLogger interface {
+	LogQuery(ctx context.Context, fields map[string]interface{})
+}
+
+// LogFormat selects how LoggingCollector renders a QueryStatus when no
+// StructuredLogger has been set and it falls back to the classic Logger.
+type LogFormat uint8
+
+const (
+	// LogFormatText renders QueryStatus as the multi-line, human-readable
+	// text produced by QueryStatus.String. This is the default.
+	LogFormatText LogFormat = iota
+
+	// LogFormatJSON renders QueryStatus as a single line of JSON built from
+	// QueryStatus.Fields.
+	LogFormatJSON
+)
+
+// Formatter renders a log level and message (plus, where available,
+// structured fields) into the bytes handed to a Logger. TextFormatter
+// reproduces the historical "LEVEL\nmessage" shape; JSONFormatter emits a
+// single line of JSON. Implementing Formatter lets the output shape vary
+// independently of the level-gating logic in loggingCollector.log.
+type Formatter interface {
+	Format(level LogLevel, msg string, fields map[string]interface{}) ([]byte, error)
+}
+
+// TextFormatter is the default Formatter. It ignores fields and renders the
+// event's level name followed by msg, matching the collector's original
+// plain-text output.
+type TextFormatter struct{}
+
+func (TextFormatter) Format(level LogLevel, msg string, fields map[string]interface{}) ([]byte, error) {
+	return []byte(logLevels[level] + "\n" + msg), nil
+}
+
+// JSONFormatter renders a single line of JSON: every entry in fields plus
+// "level" and "msg". msg is always included, even when fields is non-empty
+// (e.g. a LogEntry's accumulated WithField data alongside a real freeform
+// message) — fields is supplemental context, not a replacement for msg.
+type JSONFormatter struct{}
+
+func (JSONFormatter) Format(level LogLevel, msg string, fields map[string]interface{}) ([]byte, error) {
+	entry := make(map[string]interface{}, len(fields)+2)
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["level"] = logLevels[level]
+	entry["msg"] = msg
+	return json.Marshal(entry)
+}
+
+// JSONLogger is a StructuredLogger that writes each query as one line of
+// JSON to w. It only depends on encoding/json, making it a reasonable
+// default sink when no external structured logging library is in use.
+type JSONLogger struct {
+	w io.Writer
+}
+
+// NewJSONLogger creates a JSONLogger that writes to w.
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{w: w}
+}
+
+func (j *JSONLogger) LogQuery(ctx context.Context, fields map[string]interface{}) {
+	buf, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	j.w.Write(append(buf, '\n'))
+}
+
+// FieldLogger is a minimal structured-logging interface modeled on
+// logrus.FieldLogger and zap.SugaredLogger. It is small enough that either
+// can be wired in with a one-line wrapper, so this package does not need a
+// hard dependency on either library.
+type FieldLogger interface {
+	WithFields(fields map[string]interface{}) FieldLogger
+	Info(args ...interface{})
+}
+
+// FieldLoggerAdapter adapts a FieldLogger (e.g. a thin wrapper around a
+// logrus.Logger or zap.SugaredLogger) into a StructuredLogger that can be
+// passed to LoggingCollector.SetLogger.
+type FieldLoggerAdapter struct {
+	FieldLogger
+}
+
+func (a *FieldLoggerAdapter) LogQuery(ctx context.Context, fields map[string]interface{}) {
+	a.FieldLogger.WithFields(fields).Info("query")
+}
+
+// QueryHook is fired after every query whose level is contained in
+// Levels(), independently of the collector's configured threshold (a hook
+// for LogLevelError still fires even when the collector itself is set to
+// LogLevelFatal). Modeled on logrus hooks, this lets callers attach
+// downstream sinks such as Prometheus metrics, OpenTelemetry spans or
+// error-reporting services without replacing the base Logger.
+type QueryHook interface {
+	Levels() []LogLevel
+	Fire(*QueryStatus) error
+}
+
+// Sampler decides whether a query log event should actually be emitted. It
+// is consulted after level filtering but before formatting and before the
+// event reaches Logger/StructuredLogger, so that high-QPS deployments don't
+// pay the cost of rendering a Trace/Debug line per query. It has no effect
+// on QueryHook dispatch, which by design always runs regardless of
+// filtering (see QueryHook).
+type Sampler interface {
+	Sample(level LogLevel, q *QueryStatus) bool
+}
+
+// RateSampler is a Sampler implementing a token-bucket rate limiter: at most
+// RatePerSecond events are allowed through per second, tracked
+// independently per LogLevel.
+type RateSampler struct {
+	RatePerSecond float64
+
+	mu      sync.Mutex
+	buckets map[LogLevel]*rateBucket
+}
+
+type rateBucket struct {
+	tokens   float64
+	lastFill time.Time
+}
+
+// NewRateSampler creates a RateSampler allowing ratePerSecond events per
+// second, per LogLevel.
+func NewRateSampler(ratePerSecond float64) *RateSampler {
+	return &RateSampler{
+		RatePerSecond: ratePerSecond,
+		buckets:       make(map[LogLevel]*rateBucket),
+	}
+}
+
+func (s *RateSampler) Sample(level LogLevel, q *QueryStatus) bool {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[level]
+	if !ok {
+		b = &rateBucket{tokens: s.RatePerSecond, lastFill: now}
+		s.buckets[level] = b
+	}
+
+	if elapsed := now.Sub(b.lastFill).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * s.RatePerSecond
+		if b.tokens > s.RatePerSecond {
+			b.tokens = s.RatePerSecond
+		}
+		b.lastFill = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// BurstSampler implements a "first N then every Mth" sampling strategy: the
+// first `First` occurrences of an identical normalized query are logged,
+// and every `Every`th occurrence after that. Queries are grouped by an
+// fnv64 hash of the whitespace-normalized Query string (the same
+// normalization QueryStatus.String and QueryStatus.Fields already apply via
+// reInvisibleChars).
+type BurstSampler struct {
+	First int
+	Every int
+
+	mu     sync.Mutex
+	counts map[uint64]int
+}
+
+// NewBurstSampler creates a BurstSampler logging the first `first`
+// occurrences of each distinct query and every `every`th occurrence
+// thereafter.
+func NewBurstSampler(first, every int) *BurstSampler {
+	return &BurstSampler{First: first, Every: every, counts: make(map[uint64]int)}
+}
+
+func (s *BurstSampler) Sample(level LogLevel, q *QueryStatus) bool {
+	key := normalizedQueryHash(q.Query)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counts[key]++
+	n := s.counts[key]
+
+	if n <= s.First {
+		return true
+	}
+	if s.Every <= 0 {
+		return false
+	}
+	return (n-s.First)%s.Every == 0
+}
+
+func normalizedQueryHash(query string) uint64 {
+	query = reInvisibleChars.ReplaceAllString(query, ` `)
+	query = strings.TrimSpace(query)
+
+	h := fnv.New64()
+	h.Write([]byte(query))
+	return h.Sum64()
+}
+
+// LogEntry is an immutable, field-carrying handle onto a LoggingCollector,
+// returned by WithField/WithContext. It exposes the same logging methods as
+// LoggingCollector (Trace..Panic), so it can be used as a drop-in
+// replacement at call sites, while its accumulated fields are merged into
+// any QueryStatus built from a context.Context that carries it (see
+// NewContext/FromContext). This is the same WithField/WithContext pattern
+// logrus and klog use to correlate request-scoped log lines.
+type LogEntry struct {
+	collector *loggingCollector
+	ctx       context.Context
+	fields    map[string]interface{}
+}
+
+// WithField returns a new LogEntry with key/value added to the fields
+// carried by e, leaving e itself unchanged.
+func (e *LogEntry) WithField(key string, value interface{}) *LogEntry {
+	fields := make(map[string]interface{}, len(e.fields)+1)
+	for k, v := range e.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	entry := &LogEntry{collector: e.collector, fields: fields}
+	if e.ctx != nil {
+		entry.ctx = NewContext(e.ctx, entry)
+	}
+	return entry
+}
+
+// WithContext returns a new LogEntry carrying ctx, leaving e itself
+// unchanged. The returned LogEntry wraps ctx with NewContext itself, so
+// Context() yields a context.Context that already carries the entry back
+// out via FromContext — callers don't need a separate manual NewContext
+// call to get query correlation.
+func (e *LogEntry) WithContext(ctx context.Context) *LogEntry {
+	entry := &LogEntry{collector: e.collector, fields: e.fields}
+	entry.ctx = NewContext(ctx, entry)
+	return entry
+}
+
+// Context returns the context.Context last attached via WithContext,
+// already wrapped so that FromContext(entry.Context()) returns entry. It is
+// nil if WithContext was never called.
+func (e *LogEntry) Context() context.Context {
+	return e.ctx
+}
+
+func (e *LogEntry) log(level LogLevel, f interface{}, v ...interface{}) {
+	e.collector.logFormatted(level, fmt.Sprintf("%v", f), e.fields, v...)
+}
+
+func (e *LogEntry) Trace(format interface{}, v ...interface{}) { e.log(LogLevelTrace, format, v...) }
+func (e *LogEntry) Debug(format interface{}, v ...interface{}) { e.log(LogLevelDebug, format, v...) }
+func (e *LogEntry) Info(format interface{}, v ...interface{})  { e.log(LogLevelInfo, format, v...) }
+func (e *LogEntry) Warn(format interface{}, v ...interface{})  { e.log(LogLevelWarn, format, v...) }
+func (e *LogEntry) Error(format interface{}, v ...interface{}) { e.log(LogLevelError, format, v...) }
+func (e *LogEntry) Fatal(format interface{}, v ...interface{}) { e.log(LogLevelFatal, format, v...) }
+func (e *LogEntry) Panic(format interface{}, v ...interface{}) { e.log(LogLevelPanic, format, v...) }
+
+type logEntryContextKey struct{}
+
+// NewContext returns a copy of ctx carrying entry. A QueryStatus built from
+// the returned context (see FromContext and QueryStatus.Fields) inherits
+// entry's fields automatically, which is how DB queries get correlated with
+// the HTTP/request-scoped log lines that created entry.
+func NewContext(ctx context.Context, entry *LogEntry) context.Context {
+	return context.WithValue(ctx, logEntryContextKey{}, entry)
+}
+
+// FromContext returns the LogEntry previously attached to ctx with
+// NewContext, if any.
+func FromContext(ctx context.Context) (*LogEntry, bool) {
+	entry, ok := ctx.Value(logEntryContextKey{}).(*LogEntry)
+	return entry, ok
+}
+
 // LoggingCollector represents a logging collector. You can pass a logging
 // collector to db.DefaultSettings.SetLogger(myCollector) to make it collect
 // db.QueryStatus messages after executing a query.
 type LoggingCollector interface {
-	SetLogger(Logger)
+	// SetLogger accepts either a classic Logger (Printf/Fatalf/Panicf) or a
+	// StructuredLogger (LogQuery with a field map), and a nil clears it back
+	// to the package default. Passing any other type panics.
+	SetLogger(logger interface{})
 	Logger() Logger
+	StructuredLogger() StructuredLogger
+
+	SetFormat(LogFormat)
+	Format() LogFormat
+
+	// SetFormatter overrides the Formatter used to render log output,
+	// taking precedence over SetFormat until SetFormat is called again.
+	SetFormatter(Formatter)
+	Formatter() Formatter
 
 	SetLevel(LogLevel)
 	Level() LogLevel
 
+	// Log dispatches q to the configured Logger or StructuredLogger,
+	// according to Level and Format.
+	Log(q *QueryStatus)
+
+	// AddHook registers hook to run after every query whose level is one of
+	// hook.Levels(), in addition to (not instead of) the normal Logger /
+	// StructuredLogger dispatch.
+	AddHook(hook QueryHook)
+
+	// SetAsyncHooks switches hook dispatch from synchronous (the default,
+	// run in the caller's goroutine) to asynchronous: QueryStatus values are
+	// queued on a channel of the given buffer size and fired from a single
+	// background goroutine, so a slow hook does not block a DB call while
+	// the buffer has room; once the buffer is full, Log waits until the
+	// background goroutine frees a slot. Once enabled it cannot be turned
+	// back off.
+	SetAsyncHooks(bufferSize int)
+
+	// WithField and WithContext return a *LogEntry that carries key/value
+	// pairs and/or a context.Context forward into every subsequent log call
+	// made through it, without mutating the collector itself.
+	WithField(key string, value interface{}) *LogEntry
+	WithContext(ctx context.Context) *LogEntry
+
+	// SetSampler installs a Sampler consulted on every query that passes
+	// level filtering; a nil Sampler (the default) logs everything.
+	SetSampler(Sampler)
+	Sampler() Sampler
+
+	// SetSlowQueryThreshold makes queries whose duration is at or above d
+	// bypass the Sampler, so pathologically slow queries are never dropped.
+	// A zero threshold (the default) disables the bypass.
+	SetSlowQueryThreshold(d time.Duration)
+	SlowQueryThreshold() time.Duration
+
 	Trace(interface{}, ...interface{})
 	Debug(interface{}, ...interface{})
 	Info(interface{}, ...interface{})
@@ -163,8 +554,20 @@ type LoggingCollector interface {
 }
 
 type loggingCollector struct {
-	level  LogLevel
-	logger Logger
+	level     LogLevel
+	format    LogFormat
+	formatter Formatter
+
+	logger           Logger
+	structuredLogger StructuredLogger
+
+	hooksMu   sync.Mutex
+	hooks     []QueryHook
+	hookQueue chan *QueryStatus
+	hookOnce  sync.Once
+
+	sampler            Sampler
+	slowQueryThreshold time.Duration
 }
 
 func (c *loggingCollector) SetLevel(level LogLevel) {
@@ -182,20 +585,220 @@ func (c *loggingCollector) Logger() Logger {
 	return c.logger
 }
 
-func (c *loggingCollector) SetLogger(logger Logger) {
-	c.logger = logger
+func (c *loggingCollector) StructuredLogger() StructuredLogger {
+	return c.structuredLogger
+}
+
+func (c *loggingCollector) SetFormat(format LogFormat) {
+	c.format = format
+	c.formatter = nil
+}
+
+func (c *loggingCollector) Format() LogFormat {
+	return c.format
+}
+
+func (c *loggingCollector) SetFormatter(formatter Formatter) {
+	c.formatter = formatter
+}
+
+func (c *loggingCollector) Formatter() Formatter {
+	if c.formatter != nil {
+		return c.formatter
+	}
+	if c.format == LogFormatJSON {
+		return JSONFormatter{}
+	}
+	return TextFormatter{}
+}
+
+func (c *loggingCollector) SetLogger(logger interface{}) {
+	switch l := logger.(type) {
+	case nil:
+		c.logger = nil
+		c.structuredLogger = nil
+	case StructuredLogger:
+		c.structuredLogger = l
+		c.logger = nil
+	case Logger:
+		c.logger = l
+		c.structuredLogger = nil
+	default:
+		panic(fmt.Sprintf("db: SetLogger: %T implements neither Logger nor StructuredLogger", logger))
+	}
+}
+
+func queryLevel(q *QueryStatus) LogLevel {
+	if q.Err != nil {
+		return LogLevelError
+	}
+	return LogLevelDebug
+}
+
+// AddHook registers hook to run after every query whose level is one of
+// hook.Levels(). It is safe to call concurrently with Log/fireHooks.
+func (c *loggingCollector) AddHook(hook QueryHook) {
+	c.hooksMu.Lock()
+	defer c.hooksMu.Unlock()
+	c.hooks = append(c.hooks, hook)
+}
+
+// hookSnapshot returns a copy of the current hook list, taken under
+// hooksMu, the same guarded-state pattern RateSampler/BurstSampler use for
+// their own counters.
+func (c *loggingCollector) hookSnapshot() []QueryHook {
+	c.hooksMu.Lock()
+	defer c.hooksMu.Unlock()
+	if len(c.hooks) == 0 {
+		return nil
+	}
+	hooks := make([]QueryHook, len(c.hooks))
+	copy(hooks, c.hooks)
+	return hooks
+}
+
+func (c *loggingCollector) WithField(key string, value interface{}) *LogEntry {
+	return (&LogEntry{collector: c}).WithField(key, value)
+}
+
+func (c *loggingCollector) WithContext(ctx context.Context) *LogEntry {
+	return (&LogEntry{collector: c}).WithContext(ctx)
+}
+
+func (c *loggingCollector) SetSampler(sampler Sampler) {
+	c.sampler = sampler
+}
+
+func (c *loggingCollector) Sampler() Sampler {
+	return c.sampler
+}
+
+func (c *loggingCollector) SetSlowQueryThreshold(d time.Duration) {
+	c.slowQueryThreshold = d
+}
+
+func (c *loggingCollector) SlowQueryThreshold() time.Duration {
+	return c.slowQueryThreshold
+}
+
+// shouldSample reports whether q, having already passed level filtering,
+// should still be emitted. Slow queries always bypass the Sampler so that
+// pathologically slow queries are never dropped from the logs.
+func (c *loggingCollector) shouldSample(level LogLevel, q *QueryStatus) bool {
+	if c.sampler == nil {
+		return true
+	}
+	if c.slowQueryThreshold > 0 && q.End.Sub(q.Start) >= c.slowQueryThreshold {
+		return true
+	}
+	return c.sampler.Sample(level, q)
+}
+
+// SetAsyncHooks enables asynchronous hook dispatch; see the LoggingCollector
+// docs for details.
+func (c *loggingCollector) SetAsyncHooks(bufferSize int) {
+	c.hookOnce.Do(func() {
+		queue := make(chan *QueryStatus, bufferSize)
+
+		c.hooksMu.Lock()
+		c.hookQueue = queue
+		c.hooksMu.Unlock()
+
+		go func() {
+			for q := range queue {
+				c.fireHooks(q)
+			}
+		}()
+	})
+}
+
+// dispatchHooks sends q down the async queue if SetAsyncHooks was called, or
+// runs the hooks synchronously otherwise. hookQueue is read under hooksMu,
+// the same mutex SetAsyncHooks uses to set it, so the two never race.
+func (c *loggingCollector) dispatchHooks(q *QueryStatus) {
+	c.hooksMu.Lock()
+	queue := c.hookQueue
+	c.hooksMu.Unlock()
+
+	if queue != nil {
+		queue <- q
+		return
+	}
+	c.fireHooks(q)
+}
+
+func (c *loggingCollector) fireHooks(q *QueryStatus) {
+	hooks := c.hookSnapshot()
+	if len(hooks) == 0 {
+		return
+	}
+
+	level := queryLevel(q)
+	for _, hook := range hooks {
+		for _, hookLevel := range hook.Levels() {
+			if hookLevel == level {
+				if err := hook.Fire(q); err != nil {
+					c.Logger().Printf("db: hook error: %v", err)
+				}
+				break
+			}
+		}
+	}
+}
+
+// Log dispatches q to the collector's configured logger and hooks. Queries
+// that returned an error are logged at LogLevelError, everything else at
+// LogLevelDebug. Hooks run whenever their declared level matches, regardless
+// of the collector's threshold; the Logger/StructuredLogger dispatch below
+// is still subject to level gating and, after that, to the configured
+// Sampler. When a StructuredLogger has been set it takes precedence over
+// Format.
+func (c *loggingCollector) Log(q *QueryStatus) {
+	level := queryLevel(q)
+
+	c.dispatchHooks(q)
+
+	if level < c.level {
+		return
+	}
+
+	if !c.shouldSample(level, q) {
+		return
+	}
+
+	if c.structuredLogger != nil {
+		c.structuredLogger.LogQuery(q.Context, q.Fields())
+		return
+	}
+
+	c.logFormatted(level, q.String(), q.Fields())
 }
 
 func (c *loggingCollector) log(level LogLevel, f interface{}, v ...interface{}) {
+	c.logFormatted(level, fmt.Sprintf("%v", f), nil, v...)
+}
+
+// logFormatted renders msg/fields through the configured Formatter and
+// dispatches it to the configured Logger at the event's own level. Both the
+// prefix/shape (via Formatter) and the Panicf/Fatalf/Printf choice use
+// level, the severity of this particular event, never the collector's
+// threshold (c.level), which only gates whether the event is emitted at
+// all.
+func (c *loggingCollector) logFormatted(level LogLevel, msg string, fields map[string]interface{}, v ...interface{}) {
 	if level < c.level {
 		return
 	}
-	format := logLevels[c.level] + "\n" + fmt.Sprintf("%v", f)
 
-	if c.level >= LogLevelPanic {
+	buf, err := c.Formatter().Format(level, msg, fields)
+	if err != nil {
+		buf = []byte(msg)
+	}
+	format := string(buf)
+
+	if level >= LogLevelPanic {
 		c.Logger().Panicf(format, v...)
 	}
-	if c.level >= LogLevelFatal {
+	if level >= LogLevelFatal {
 		c.Logger().Fatalf(format, v...)
 	}
 	c.Logger().Printf(format, v...)
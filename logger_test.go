@@ -0,0 +1,389 @@
+// Copyright (c) 2012-present The upper.io/db authors. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type captureLogger struct {
+	lastFormat string
+}
+
+func (l *captureLogger) Printf(format string, v ...interface{}) {
+	l.lastFormat = format
+}
+
+func (l *captureLogger) Fatalf(format string, v ...interface{}) {
+	l.lastFormat = format
+}
+
+func (l *captureLogger) Panicf(format string, v ...interface{}) {
+	l.lastFormat = format
+	panic(format)
+}
+
+func TestLogPanicsOnEventLevelNotThreshold(t *testing.T) {
+	c := &loggingCollector{level: LogLevelDebug, logger: &captureLogger{}}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Panic() did not panic even though a low collector threshold let it through")
+		}
+	}()
+
+	c.Panic("boom")
+}
+
+func TestLogPrefixUsesEventLevel(t *testing.T) {
+	logger := &captureLogger{}
+	c := &loggingCollector{level: LogLevelDebug, logger: logger}
+
+	c.Warn("hello")
+
+	if !strings.HasPrefix(logger.lastFormat, logLevels[LogLevelWarn]+"\n") {
+		t.Fatalf("expected prefix %q, got %q", logLevels[LogLevelWarn], logger.lastFormat)
+	}
+}
+
+func TestLogGatesOnEventLevel(t *testing.T) {
+	logger := &captureLogger{}
+	c := &loggingCollector{level: LogLevelWarn, logger: logger}
+
+	c.Debug("should not be logged")
+	if logger.lastFormat != "" {
+		t.Fatalf("expected Debug below threshold to be gated, got %q", logger.lastFormat)
+	}
+
+	c.Error("should be logged")
+	if !strings.HasPrefix(logger.lastFormat, logLevels[LogLevelError]+"\n") {
+		t.Fatalf("expected prefix %q, got %q", logLevels[LogLevelError], logger.lastFormat)
+	}
+}
+
+func TestJSONFormatterKeepsMsgAlongsideFields(t *testing.T) {
+	buf, err := JSONFormatter{}.Format(LogLevelInfo, "user logged in", map[string]interface{}{
+		"request_id": "abc",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf, &entry); err != nil {
+		t.Fatalf("unexpected error unmarshaling %s: %v", buf, err)
+	}
+
+	if entry["msg"] != "user logged in" {
+		t.Fatalf("expected msg to survive alongside fields, got %v", entry)
+	}
+	if entry["request_id"] != "abc" {
+		t.Fatalf("expected request_id field to survive, got %v", entry)
+	}
+}
+
+func TestRateSamplerDeniesOnceBucketIsDrained(t *testing.T) {
+	s := NewRateSampler(2)
+	q := &QueryStatus{}
+
+	if !s.Sample(LogLevelDebug, q) {
+		t.Fatal("expected 1st immediate sample to be allowed")
+	}
+	if !s.Sample(LogLevelDebug, q) {
+		t.Fatal("expected 2nd immediate sample to be allowed")
+	}
+	if s.Sample(LogLevelDebug, q) {
+		t.Fatal("expected 3rd immediate sample to be denied")
+	}
+}
+
+func TestBurstSamplerFirstNThenEveryM(t *testing.T) {
+	s := NewBurstSampler(2, 3)
+	q := &QueryStatus{Query: "select 1"}
+
+	got := make([]bool, 8)
+	for i := range got {
+		got[i] = s.Sample(LogLevelDebug, q)
+	}
+
+	want := []bool{true, true, false, false, true, false, false, true}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d: got %v, want %v (all: %v)", i, got[i], want[i], got)
+		}
+	}
+}
+
+func TestBurstSamplerKeysByNormalizedQuery(t *testing.T) {
+	s := NewBurstSampler(1, 0)
+
+	a := &QueryStatus{Query: "select  1"}
+	b := &QueryStatus{Query: "select\n1"}
+
+	if !s.Sample(LogLevelDebug, a) {
+		t.Fatal("expected first occurrence of a to be allowed")
+	}
+	if s.Sample(LogLevelDebug, b) {
+		t.Fatal("expected b, which normalizes to the same query as a, to share a's counter and be denied")
+	}
+}
+
+func TestSlowQueryBypassesSampler(t *testing.T) {
+	c := &loggingCollector{}
+	c.SetSampler(NewBurstSampler(0, 0)) // denies everything
+	c.SetSlowQueryThreshold(time.Millisecond)
+
+	start := time.Unix(0, 0)
+
+	slow := &QueryStatus{Start: start, End: start.Add(10 * time.Millisecond)}
+	if !c.shouldSample(LogLevelDebug, slow) {
+		t.Fatal("expected a query above the slow-query threshold to bypass the sampler")
+	}
+
+	fast := &QueryStatus{Start: start, End: start.Add(time.Microsecond)}
+	if c.shouldSample(LogLevelDebug, fast) {
+		t.Fatal("expected a query below the slow-query threshold to still be sampled")
+	}
+}
+
+type recordingHook struct {
+	mu    sync.Mutex
+	fired int
+}
+
+func (h *recordingHook) Levels() []LogLevel { return []LogLevel{LogLevelDebug, LogLevelError} }
+
+func (h *recordingHook) Fire(q *QueryStatus) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fired++
+	return nil
+}
+
+func (h *recordingHook) Fired() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.fired
+}
+
+// TestAsyncHooksDoesNotRaceWithLog exercises SetAsyncHooks racing against
+// concurrent Log calls; run with -race to catch the hookQueue data race.
+func TestAsyncHooksDoesNotRaceWithLog(t *testing.T) {
+	c := &loggingCollector{level: LogLevelTrace, logger: &captureLogger{}}
+	hook := &recordingHook{}
+	c.AddHook(hook)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		c.SetAsyncHooks(8)
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			c.Log(&QueryStatus{})
+		}
+	}()
+
+	wg.Wait()
+
+	deadline := time.Now().Add(time.Second)
+	for hook.Fired() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if hook.Fired() == 0 {
+		t.Fatal("expected hook to have fired at least once")
+	}
+}
+
+func TestAddHookThenFireHooksRunsForMatchingLevel(t *testing.T) {
+	c := &loggingCollector{level: LogLevelTrace, logger: &captureLogger{}}
+	hook := &recordingHook{}
+	c.AddHook(hook)
+
+	c.Log(&QueryStatus{}) // LogLevelDebug, matches hook.Levels()
+	if hook.Fired() != 1 {
+		t.Fatalf("expected hook to fire once, fired %d times", hook.Fired())
+	}
+
+	c.Log(&QueryStatus{}) // second query
+	if hook.Fired() != 2 {
+		t.Fatalf("expected hook to fire twice, fired %d times", hook.Fired())
+	}
+}
+
+func TestJSONLoggerWritesOneLineOfJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf)
+
+	logger.LogQuery(context.Background(), map[string]interface{}{"query": "select 1"})
+
+	if !strings.HasSuffix(buf.String(), "\n") {
+		t.Fatalf("expected output to end in a newline, got %q", buf.String())
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("unexpected error unmarshaling %s: %v", buf.String(), err)
+	}
+	if entry["query"] != "select 1" {
+		t.Fatalf("expected query field to round-trip, got %v", entry)
+	}
+}
+
+type fakeFieldLogger struct {
+	fields   map[string]interface{}
+	lastArgs []interface{}
+}
+
+func (f *fakeFieldLogger) WithFields(fields map[string]interface{}) FieldLogger {
+	f.fields = fields
+	return f
+}
+
+func (f *fakeFieldLogger) Info(args ...interface{}) {
+	f.lastArgs = args
+}
+
+func TestFieldLoggerAdapterForwardsFields(t *testing.T) {
+	fake := &fakeFieldLogger{}
+	adapter := &FieldLoggerAdapter{FieldLogger: fake}
+
+	adapter.LogQuery(context.Background(), map[string]interface{}{"query": "select 1"})
+
+	if fake.fields["query"] != "select 1" {
+		t.Fatalf("expected fields to be forwarded to WithFields, got %v", fake.fields)
+	}
+	if len(fake.lastArgs) != 1 {
+		t.Fatalf("expected Info to be called with the log message, got %v", fake.lastArgs)
+	}
+}
+
+func TestSetLoggerAcceptsLoggerOrStructuredLogger(t *testing.T) {
+	c := &loggingCollector{}
+
+	c.SetLogger(&captureLogger{})
+	if c.Logger() == nil || c.StructuredLogger() != nil {
+		t.Fatalf("expected a classic Logger to be set and StructuredLogger cleared")
+	}
+
+	c.SetLogger(NewJSONLogger(&bytes.Buffer{}))
+	if c.StructuredLogger() == nil {
+		t.Fatalf("expected a StructuredLogger to be set")
+	}
+
+	c.SetLogger(nil)
+	if c.Logger() != defaultLogger || c.StructuredLogger() != nil {
+		t.Fatalf("expected SetLogger(nil) to reset to the package default")
+	}
+}
+
+func TestSetLoggerPanicsOnUnsupportedType(t *testing.T) {
+	c := &loggingCollector{}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected SetLogger to panic on a type implementing neither Logger nor StructuredLogger")
+		}
+	}()
+
+	c.SetLogger(42)
+}
+
+func TestLogEntryWithFieldAccumulates(t *testing.T) {
+	c := &loggingCollector{}
+	entry := c.WithField("a", 1).WithField("b", 2)
+
+	if entry.fields["a"] != 1 || entry.fields["b"] != 2 {
+		t.Fatalf("expected both fields to accumulate, got %v", entry.fields)
+	}
+}
+
+func TestLogEntryWithFieldIsImmutable(t *testing.T) {
+	c := &loggingCollector{}
+	base := c.WithField("a", 1)
+	_ = base.WithField("b", 2)
+
+	if _, ok := base.fields["b"]; ok {
+		t.Fatal("expected the base entry to be unaffected by a derived WithField call")
+	}
+}
+
+func TestNewContextFromContextRoundTrip(t *testing.T) {
+	c := &loggingCollector{}
+	entry := c.WithField("request_id", "abc")
+
+	ctx := NewContext(context.Background(), entry)
+
+	got, ok := FromContext(ctx)
+	if !ok || got != entry {
+		t.Fatalf("expected FromContext to return the entry stored by NewContext, got %v, %v", got, ok)
+	}
+}
+
+func TestFromContextWithoutEntry(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Fatal("expected FromContext to report false for a context without an entry")
+	}
+}
+
+// TestLogEntryWithContextCarriesEntry is a regression test for a bug where
+// WithContext stored ctx on the LogEntry but nothing ever wired the entry
+// back into it, so Log().WithField(...).WithContext(ctx) silently dropped
+// the fields from query correlation.
+func TestLogEntryWithContextCarriesEntry(t *testing.T) {
+	c := &loggingCollector{}
+	entry := c.WithField("request_id", "abc").WithContext(context.Background())
+
+	got, ok := FromContext(entry.Context())
+	if !ok {
+		t.Fatal("expected entry.Context() to carry the entry back out via FromContext")
+	}
+	if got.fields["request_id"] != "abc" {
+		t.Fatalf("expected the round-tripped entry to keep its fields, got %v", got.fields)
+	}
+}
+
+func TestQueryStatusFieldsMergesLogEntryFromContext(t *testing.T) {
+	c := &loggingCollector{}
+	entry := c.WithField("request_id", "abc")
+	ctx := NewContext(context.Background(), entry)
+
+	fields := (&QueryStatus{Query: "select 1", Context: ctx}).Fields()
+
+	if fields["request_id"] != "abc" {
+		t.Fatalf("expected LogEntry fields to merge into QueryStatus.Fields, got %v", fields)
+	}
+	if fields["query"] != "select 1" {
+		t.Fatalf("expected the query field to still be present, got %v", fields)
+	}
+}